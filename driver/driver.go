@@ -1,26 +1,59 @@
 package driver
 
 import (
-	"context"
 	"fmt"
+	"os"
 	"time"
 
-	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnflag"
 	"github.com/rancher/machine/libmachine/state"
+
+	"github.com/TheRideru/rancher-hcloud-driver/providers"
+	"github.com/TheRideru/rancher-hcloud-driver/providers/capsul"
+	"github.com/TheRideru/rancher-hcloud-driver/providers/hcloud"
+	"github.com/TheRideru/rancher-hcloud-driver/providers/hrobot"
+)
+
+// serverProviderHCloud, serverProviderHRobot, and serverProviderCapsul are
+// the accepted values of --server-provider.
+const (
+	serverProviderHCloud = "hcloud"
+	serverProviderHRobot = "hrobot"
+	serverProviderCapsul = "capsul"
 )
 
-// Driver implements the Docker Machine interface for Hetzner Cloud.
+// Driver implements the Docker Machine interface for Hetzner Cloud (and,
+// via --server-provider, other backends behind the providers.Provider
+// interface).
 type Driver struct {
 	*drivers.BaseDriver // Embeds MachineName, StorePath, SSHUser, SSHKeyPath, IPAddress, etc.
 
-	APIToken   string // Hetzner API token
-	ServerID   int64  // ID of the created server
-	SSHKeyID   int64  // ID of the uploaded SSH key
-	ServerType string // e.g. "cx11"
-	Image      string // e.g. "ubuntu-20.04"
-	Region     string // e.g. "nbg1"
+	APIToken       string // API token for the selected server provider
+	ServerProvider string // "hcloud" (default), "hrobot", or "capsul"
+	ServerID       int64  // ID of the created server
+	SSHKeyID       int64  // ID of the uploaded or reused SSH key
+	ServerType     string // e.g. "cx11"
+	Image          string // e.g. "ubuntu-20.04"
+	Region         string // e.g. "nbg1"
+
+	SSHKeyType     string // rsa, ecdsa, or ed25519; only used when generating a new key
+	ExistingSSHKey string // name, ID, or path to a local private key of an existing SSH key to reuse
+	SSHKeyCreated  bool   // true if this driver uploaded SSHKeyID itself, so Remove() should delete it
+
+	Networks       []string          // names or IDs of existing private networks to attach
+	Firewalls      []string          // names or IDs of existing firewalls to apply
+	SSHKeys        []string          // names or IDs of existing SSH keys to attach, in addition to the generated one
+	Volumes        []string          // "<name>:<size-gb>" to create a volume, or "<name-or-id>" to attach an existing one
+	PlacementGroup string            // name or ID of an existing placement group
+	Labels         map[string]string // extra labels merged onto the server's labels; SSH keys and volumes the driver creates do not get them
+	UserData       string            // cloud-init content, inline or loaded from a file path
+
+	WaitTimeout      time.Duration // how long to wait for SSH (and cloud-init) to come up in Create()
+	WaitForCloudInit bool          // if true and UserData is set, Create() also waits for cloud-init to finish
+
+	CreatedVolumeIDs []int64 // IDs of volumes this driver created, so Remove() can clean them up
 }
 
 // NewDriver returns a fresh instance of Driver with BaseDriver initialized.
@@ -39,9 +72,15 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 	return []mcnflag.Flag{
 		mcnflag.StringFlag{
 			Name:   "hetzner-api-token",
-			Usage:  "Hetzner Cloud API Token",
+			Usage:  "API token for the selected --server-provider",
 			EnvVar: "HETZNER_API_TOKEN",
 		},
+		mcnflag.StringFlag{
+			Name:   "server-provider",
+			Usage:  "Server backend to provision against: hcloud (Hetzner Cloud), hrobot (Hetzner Robot dedicated), or capsul; hrobot and capsul are stubs that return an error for every operation",
+			EnvVar: "SERVER_PROVIDER",
+			Value:  serverProviderHCloud,
+		},
 		mcnflag.StringFlag{
 			Name:   "hetzner-server-type",
 			Usage:  "Hetzner server type (e.g. cx11)",
@@ -60,6 +99,63 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			EnvVar: "HETZNER_LOCATION",
 			Value:  "nbg1",
 		},
+		mcnflag.StringSliceFlag{
+			Name:   "hetzner-network",
+			Usage:  "Name or ID of an existing private network to attach (may be passed multiple times)",
+			EnvVar: "HETZNER_NETWORKS",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   "hetzner-firewall",
+			Usage:  "Name or ID of an existing firewall to apply (may be passed multiple times)",
+			EnvVar: "HETZNER_FIREWALLS",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   "hetzner-ssh-key",
+			Usage:  "Name or ID of an existing SSH key to attach in addition to the generated one (may be passed multiple times)",
+			EnvVar: "HETZNER_SSH_KEYS",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   "hetzner-volume",
+			Usage:  "\"name:size-gb\" to create a new volume, or \"name-or-id\" to attach an existing one (may be passed multiple times)",
+			EnvVar: "HETZNER_VOLUMES",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-placement-group",
+			Usage:  "Name or ID of an existing placement group to assign the server to",
+			EnvVar: "HETZNER_PLACEMENT_GROUP",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   "hetzner-label",
+			Usage:  "\"key=value\" label to attach to the server (not to the SSH key or volumes this driver creates; may be passed multiple times)",
+			EnvVar: "HETZNER_LABELS",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-user-data",
+			Usage:  "Cloud-init user-data for the server, as a file path or inline content",
+			EnvVar: "HETZNER_USER_DATA",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-ssh-key-type",
+			Usage:  "Type of SSH key to generate when --hetzner-existing-ssh-key is not set (rsa, ecdsa, ed25519)",
+			EnvVar: "HETZNER_SSH_KEY_TYPE",
+			Value:  "rsa",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-existing-ssh-key",
+			Usage:  "Name, ID, or path to a local private key of an existing SSH key to reuse instead of generating one",
+			EnvVar: "HETZNER_EXISTING_SSH_KEY",
+		},
+		mcnflag.IntFlag{
+			Name:   "hetzner-wait-timeout",
+			Usage:  "Seconds to wait for SSH (and, if enabled, cloud-init) to become ready in Create()",
+			EnvVar: "HETZNER_WAIT_TIMEOUT",
+			Value:  int(defaultWaitTimeout / time.Second),
+		},
+		mcnflag.BoolFlag{
+			Name:   "hetzner-wait-for-cloud-init",
+			Usage:  "Wait for `cloud-init status --wait` to report done before Create() returns (only applies when --hetzner-user-data is set)",
+			EnvVar: "HETZNER_WAIT_FOR_CLOUD_INIT",
+		},
 	}
 }
 
@@ -67,13 +163,39 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 // Called automatically by Docker Machine / Rancher.
 func (d *Driver) SetConfigFromFlags(opts drivers.DriverOptions) error {
 	d.APIToken = opts.String("hetzner-api-token")
+	d.ServerProvider = opts.String("server-provider")
 	d.ServerType = opts.String("hetzner-server-type")
 	d.Image = opts.String("hetzner-image")
 	d.Region = opts.String("hetzner-location")
+	d.Networks = opts.StringSlice("hetzner-network")
+	d.Firewalls = opts.StringSlice("hetzner-firewall")
+	d.SSHKeys = opts.StringSlice("hetzner-ssh-key")
+	d.Volumes = opts.StringSlice("hetzner-volume")
+	d.PlacementGroup = opts.String("hetzner-placement-group")
+	d.UserData = opts.String("hetzner-user-data")
+	d.SSHKeyType = opts.String("hetzner-ssh-key-type")
+	d.ExistingSSHKey = opts.String("hetzner-existing-ssh-key")
+	d.WaitTimeout = time.Duration(opts.Int("hetzner-wait-timeout")) * time.Second
+	d.WaitForCloudInit = opts.Bool("hetzner-wait-for-cloud-init")
 	d.SSHUser = "root" // default for Hetzner Cloud images
 	if d.APIToken == "" {
 		return fmt.Errorf("hetzner-api-token is required")
 	}
+	switch d.ServerProvider {
+	case "", serverProviderHCloud, serverProviderHRobot, serverProviderCapsul:
+	default:
+		return fmt.Errorf("unsupported --server-provider %q: must be one of hcloud, hrobot, capsul", d.ServerProvider)
+	}
+	switch d.SSHKeyType {
+	case "", sshKeyTypeRSA, sshKeyTypeECDSA, sshKeyTypeED25519:
+	default:
+		return fmt.Errorf("unsupported hetzner-ssh-key-type %q: must be one of rsa, ecdsa, ed25519", d.SSHKeyType)
+	}
+	labels, err := parseLabels(opts.StringSlice("hetzner-label"))
+	if err != nil {
+		return err
+	}
+	d.Labels = labels
 	return nil
 }
 
@@ -85,103 +207,200 @@ func (d *Driver) PreCreateCheck() error {
 	return nil
 }
 
-// Create provisions a new Hetzner Cloud server, waits for it to be ready,
-// and records its IP (and SSH key).
-func (d *Driver) Create() error {
-	if d.StorePath == "" {
-		return fmt.Errorf("storePath is empty, cannot create SSH key")
+// getProvider constructs the providers.Provider selected by
+// --server-provider. It isn't persisted on Driver: each lifecycle method
+// constructs it fresh from APIToken and ServerProvider, the same way the
+// pre-refactor code constructed its hcloud.Client.
+func (d *Driver) getProvider() (providers.Provider, error) {
+	switch d.ServerProvider {
+	case "", serverProviderHCloud:
+		return hcloud.New(d.APIToken), nil
+	case serverProviderHRobot:
+		return hrobot.New(d.APIToken), nil
+	case serverProviderCapsul:
+		return capsul.New(d.APIToken), nil
+	default:
+		return nil, fmt.Errorf("unsupported --server-provider %q: must be one of hcloud, hrobot, capsul", d.ServerProvider)
 	}
+}
 
-	ctx := context.Background()
-	client := hcloud.NewClient(hcloud.WithToken(d.APIToken))
+// resolveSSHKey generates (or reuses) the SSH key to attach to the new
+// server via provider.UploadKey, recording whether this driver now owns it.
+func (d *Driver) resolveSSHKey(provider providers.Provider) (int64, error) {
+	keyName := fmt.Sprintf("rancher-%s", d.MachineName)
 
-	// 1) Generate SSH key + write private key locally:
-	publicKey, err := d.generateSSHKey()
-	if err != nil {
-		return fmt.Errorf("generating SSH key: %w", err)
+	var publicKey []byte
+	if d.ExistingSSHKey == "" {
+		pk, err := d.generateSSHKey(d.SSHKeyType)
+		if err != nil {
+			return 0, fmt.Errorf("generating SSH key: %w", err)
+		}
+		publicKey = pk
+	} else if info, err := os.Stat(d.ExistingSSHKey); err == nil && !info.IsDir() {
+		// --hetzner-existing-ssh-key named a local private key file rather
+		// than a name/ID already known to the provider: point the driver's
+		// own SSH client (waitForSSH, RunSSHCommandFromDriver, ...) at it.
+		d.SSHKeyPath = d.ExistingSSHKey
 	}
 
-	// 2) Upload public key to Hetzner:
-	keyName := fmt.Sprintf("rancher-%s", d.MachineName)
-	hkey, _, err := client.SSHKey.Create(ctx, hcloud.SSHKeyCreateOpts{
-		Name:      keyName,
-		PublicKey: string(publicKey),
-	})
+	keyID, created, err := provider.UploadKey(keyName, d.ExistingSSHKey, publicKey, d.StorePath)
 	if err != nil {
-		return fmt.Errorf("creating SSH key in Hetzner Cloud: %w", err)
+		return 0, err
 	}
-	d.SSHKeyID = hkey.ID
+	d.SSHKeyCreated = created
+	return keyID, nil
+}
 
-	// 3) Create server with that SSH key attached:
-	serverOpts := hcloud.ServerCreateOpts{
-		Name:       d.MachineName,
-		ServerType: &hcloud.ServerType{Name: d.ServerType},
-		Image:      &hcloud.Image{Name: d.Image},
-		Location:   &hcloud.Location{Name: d.Region},
-		SSHKeys:    []*hcloud.SSHKey{hkey},
+// Create provisions a new server through the selected --server-provider,
+// waits for it to be ready, and records its IP (and SSH key).
+func (d *Driver) Create() error {
+	if d.StorePath == "" {
+		return fmt.Errorf("storePath is empty, cannot create SSH key")
 	}
-	createResult, _, err := client.Server.Create(ctx, serverOpts)
+
+	provider, err := d.getProvider()
 	if err != nil {
-		return fmt.Errorf("error creating Hetzner server: %w", err)
+		return err
 	}
 
-	server := createResult.Server
-	d.ServerID = server.ID
-
-	// 4) Wait for the “create” action to complete:
-	if createResult.Action != nil {
-		if err := client.Action.WaitForFunc(ctx,
-			func(a *hcloud.Action) error { return nil },
-			createResult.Action,
-		); err != nil {
-			return fmt.Errorf("waiting for server creation: %w", err)
+	// 1) Idempotency: if a previous Create() for this machine was
+	// interrupted after provisioning but before its IDs were persisted,
+	// adopt the leftover server and SSH key instead of creating duplicates.
+	if serverID, ipAddress, found, err := provider.FindServer(d.MachineName, d.StorePath); err != nil {
+		return err
+	} else if found {
+		log.Infof("Found existing server for %s, adopting it instead of creating a new one", d.MachineName)
+		d.ServerID = serverID
+		d.IPAddress = ipAddress
+	}
+	if d.ExistingSSHKey == "" && d.SSHKeyID == 0 {
+		if keyID, found, err := provider.FindKey(d.MachineName, d.StorePath); err != nil {
+			return err
+		} else if found {
+			d.SSHKeyID = keyID
+			d.SSHKeyCreated = true
+			// generateSSHKey (run by the interrupted Create() that uploaded
+			// this key) always writes the private key to the same
+			// deterministic path; re-derive it here too, since resolveSSHKey
+			// is skipped below now that SSHKeyID is already set, and it's
+			// the only other place that sets SSHKeyPath.
+			if keyPath := localSSHKeyPath(d.StorePath, d.MachineName, d.SSHKeyType); fileExists(keyPath) {
+				d.SSHKeyPath = keyPath
+			} else {
+				log.Warnf("Recovered SSH key %d for %s but its private key is missing from %s; SSH will fail", keyID, d.MachineName, keyPath)
+			}
 		}
 	}
-	// 5) Poll until the server has a public IPv4:
-	var srv *hcloud.Server
-	for i := 0; i < 30; i++ {
-		srv, _, err = client.Server.GetByID(ctx, d.ServerID)
+
+	// 2) Resolve the SSH key to use: either reuse/upload the key named by
+	// --hetzner-existing-ssh-key, or generate a fresh one of
+	// --hetzner-ssh-key-type:
+	if d.SSHKeyID == 0 {
+		keyID, err := d.resolveSSHKey(provider)
 		if err != nil {
-			return fmt.Errorf("fetching server %d: %w", d.ServerID, err)
+			return err
 		}
-		if srv.PublicNet.IPv4.IP != nil {
-			d.IPAddress = srv.PublicNet.IPv4.IP.String()
-			break
+		d.SSHKeyID = keyID
+	}
+
+	userData, err := loadUserData(d.UserData)
+	if err != nil {
+		return err
+	}
+
+	// 3) Create the server with that SSH key (and any user-requested
+	// networks/firewalls/volumes/placement group) attached, unless it was
+	// adopted above:
+	if d.ServerID == 0 {
+		result, err := provider.CreateServer(providers.CreateOpts{
+			MachineName:    d.MachineName,
+			StorePath:      d.StorePath,
+			ServerType:     d.ServerType,
+			Image:          d.Image,
+			Region:         d.Region,
+			Networks:       d.Networks,
+			Firewalls:      d.Firewalls,
+			SSHKeys:        d.SSHKeys,
+			KeyID:          d.SSHKeyID,
+			Volumes:        d.Volumes,
+			PlacementGroup: d.PlacementGroup,
+			Labels:         d.Labels,
+			UserData:       userData,
+		})
+		if err != nil {
+			return err
 		}
-		time.Sleep(2 * time.Second)
+		d.ServerID = result.ServerID
+		d.IPAddress = result.IPAddress
+		d.CreatedVolumeIDs = result.CreatedVolumeIDs
 	}
-	if d.IPAddress == "" {
-		return fmt.Errorf("server %d has no public IPv4 after timeout", d.ServerID)
+
+	// 4) Wait for SSH to actually come up before handing the node back to
+	// Rancher, which otherwise starts SSHing in immediately and can hit a
+	// not-yet-booted sshd or an in-progress cloud-init.
+	waitTimeout := d.WaitTimeout
+	if waitTimeout <= 0 {
+		waitTimeout = defaultWaitTimeout
+	}
+	if err := d.waitForSSH(waitTimeout); err != nil {
+		return err
+	}
+	if d.WaitForCloudInit && userData != "" {
+		if err := d.waitForCloudInit(waitTimeout); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// Remove deletes the Hetzner server and the uploaded SSH key.
+// Remove deletes the server, the SSH key, and the volumes this driver
+// created. It recovers their IDs via FindServer/FindKey/FindVolumes if the
+// driver's own persisted state lost them, e.g. after a Create() that
+// crashed between provisioning and the first successful state save.
 func (d *Driver) Remove() error {
-	ctx := context.Background()
-	client := hcloud.NewClient(hcloud.WithToken(d.APIToken))
+	provider, err := d.getProvider()
+	if err != nil {
+		return err
+	}
 
-	// 1) Delete the server
-	if d.ServerID != 0 {
-		delRes, _, err := client.Server.DeleteWithResult(ctx, &hcloud.Server{ID: d.ServerID})
-		if err != nil {
-			return fmt.Errorf("deleting server %d: %w", d.ServerID, err)
+	serverID := d.ServerID
+	if serverID == 0 {
+		if id, _, found, err := provider.FindServer(d.MachineName, d.StorePath); err != nil {
+			return err
+		} else if found {
+			log.Infof("Recovered server for %s via label lookup", d.MachineName)
+			serverID = id
 		}
-		if delRes.Action != nil {
-			if err := client.Action.WaitForFunc(ctx,
-				func(a *hcloud.Action) error { return nil },
-				delRes.Action,
-			); err != nil {
-				return fmt.Errorf("waiting for server deletion: %w", err)
+	}
+	if serverID != 0 {
+		volumeIDs := d.CreatedVolumeIDs
+		if len(volumeIDs) == 0 {
+			if ids, err := provider.FindVolumes(d.MachineName, d.StorePath); err != nil {
+				return err
+			} else if len(ids) > 0 {
+				log.Infof("Recovered %d volume(s) for %s via label lookup", len(ids), d.MachineName)
+				volumeIDs = ids
 			}
 		}
+		if err := provider.DeleteServer(serverID, volumeIDs); err != nil {
+			return err
+		}
 	}
 
-	// 2) Delete the SSH key
-	if d.SSHKeyID != 0 {
-		if _, err := client.SSHKey.Delete(ctx, &hcloud.SSHKey{ID: d.SSHKeyID}); err != nil {
-			return fmt.Errorf("deleting SSH key %d: %w", d.SSHKeyID, err)
+	// Delete the SSH key, but only if this driver uploaded it itself; a key
+	// supplied via --hetzner-existing-ssh-key is left in the project.
+	keyID, sshKeyCreated := d.SSHKeyID, d.SSHKeyCreated
+	if keyID == 0 && d.ExistingSSHKey == "" {
+		if id, found, err := provider.FindKey(d.MachineName, d.StorePath); err != nil {
+			return err
+		} else if found {
+			keyID, sshKeyCreated = id, true
+		}
+	}
+	if keyID != 0 && sshKeyCreated {
+		if err := provider.DeleteKey(keyID); err != nil {
+			return err
 		}
 	}
 
@@ -216,25 +435,17 @@ func (d *Driver) DriverName() string {
 	return "hetzner"
 }
 
-// GetState queries Hetzner for the server status (Running, Stopped, etc.)
+// GetState queries the server provider for the server status (Running,
+// Stopped, etc.)
 func (d *Driver) GetState() (state.State, error) {
 	if d.ServerID == 0 {
 		return state.Error, fmt.Errorf("server ID not set")
 	}
-	ctx := context.Background()
-	client := hcloud.NewClient(hcloud.WithToken(d.APIToken))
-	srv, _, err := client.Server.GetByID(ctx, d.ServerID)
+	provider, err := d.getProvider()
 	if err != nil {
-		return state.Error, fmt.Errorf("fetching server %d: %w", d.ServerID, err)
-	}
-	switch srv.Status {
-	case hcloud.ServerStatusRunning:
-		return state.Running, nil
-	case hcloud.ServerStatusOff:
-		return state.Stopped, nil
-	default:
-		return state.None, nil
+		return state.Error, err
 	}
+	return provider.GetStatus(d.ServerID)
 }
 
 // GetURL returns the Docker endpoint URL (tcp://<IP>:2376)
@@ -245,64 +456,43 @@ func (d *Driver) GetURL() (string, error) {
 	return fmt.Sprintf("tcp://%s:2376", d.IPAddress), nil
 }
 
-// Start powers on the VM (uses Hetzner Cloud PowerOn API)
+// Start powers on the server.
 func (d *Driver) Start() error {
 	if d.ServerID == 0 {
 		return fmt.Errorf("server ID not set")
 	}
-	ctx := context.Background()
-	client := hcloud.NewClient(hcloud.WithToken(d.APIToken))
-	srv, _, err := client.Server.GetByID(ctx, d.ServerID)
+	provider, err := d.getProvider()
 	if err != nil {
-		return fmt.Errorf("cannot fetch server %d: %w", d.ServerID, err)
+		return err
 	}
-	if srv != nil {
-		if _, _, err := client.Server.Poweron(ctx, srv); err != nil {
-			return fmt.Errorf("powering on server %d: %w", d.ServerID, err)
-		}
-	}
-	return nil
+	return provider.PowerOn(d.ServerID)
 }
 
-// Stop powers off the VM (uses Hetzner Cloud PowerOff API)
+// Stop powers off the server.
 func (d *Driver) Stop() error {
 	if d.ServerID == 0 {
 		return fmt.Errorf("server ID not set")
 	}
-	ctx := context.Background()
-	client := hcloud.NewClient(hcloud.WithToken(d.APIToken))
-	srv, _, err := client.Server.GetByID(ctx, d.ServerID)
+	provider, err := d.getProvider()
 	if err != nil {
-		return fmt.Errorf("cannot fetch server %d: %w", d.ServerID, err)
-	}
-	if srv != nil {
-		if _, _, err := client.Server.Poweroff(ctx, srv); err != nil {
-			return fmt.Errorf("powering off server %d: %w", d.ServerID, err)
-		}
+		return err
 	}
-	return nil
+	return provider.PowerOff(d.ServerID)
 }
 
-// Restart reboots the VM
+// Restart reboots the server.
 func (d *Driver) Restart() error {
 	if d.ServerID == 0 {
 		return fmt.Errorf("server ID not set")
 	}
-	ctx := context.Background()
-	client := hcloud.NewClient(hcloud.WithToken(d.APIToken))
-	srv, _, err := client.Server.GetByID(ctx, d.ServerID)
+	provider, err := d.getProvider()
 	if err != nil {
-		return fmt.Errorf("cannot fetch server %d: %w", d.ServerID, err)
-	}
-	if srv != nil {
-		if _, _, err := client.Server.Reboot(ctx, srv); err != nil {
-			return fmt.Errorf("rebooting server %d: %w", d.ServerID, err)
-		}
+		return err
 	}
-	return nil
+	return provider.Reboot(d.ServerID)
 }
 
-// Kill forcibly powers off the VM (alias for PowerOff)
+// Kill forcibly powers off the server (alias for Stop)
 func (d *Driver) Kill() error {
 	return d.Stop()
 }
@@ -1,9 +1,11 @@
 package driver
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
 	"encoding/pem"
 	"fmt"
 	"os"
@@ -13,34 +15,62 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-// generateSSHKey creates a new SSH keypair, stores the private key under
-// d.StorePath, and returns the public key bytes for uploading.
-func (d *Driver) generateSSHKey() ([]byte, error) {
+// sshKeyTypeRSA, sshKeyTypeECDSA, and sshKeyTypeED25519 are the accepted
+// values of --hetzner-ssh-key-type.
+const (
+	sshKeyTypeRSA     = "rsa"
+	sshKeyTypeECDSA   = "ecdsa"
+	sshKeyTypeED25519 = "ed25519"
+)
+
+// generateSSHKey creates a new SSH keypair of the given type, stores the
+// private key under d.StorePath, and returns the public key bytes for
+// uploading.
+func (d *Driver) generateSSHKey(keyType string) ([]byte, error) {
 	if d.StorePath == "" {
 		return nil, fmt.Errorf("storePath is empty, cannot write SSH key")
 	}
 
-	// 1) Generate RSA key
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, fmt.Errorf("generating RSA key: %w", err)
+	var signerKey interface{}
+	switch keyType {
+	case "", sshKeyTypeRSA:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("generating RSA key: %w", err)
+		}
+		signerKey = key
+	case sshKeyTypeECDSA:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generating ECDSA key: %w", err)
+		}
+		signerKey = key
+	case sshKeyTypeED25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generating ed25519 key: %w", err)
+		}
+		signerKey = key
+	default:
+		return nil, fmt.Errorf("unsupported hetzner-ssh-key-type %q: must be one of rsa, ecdsa, ed25519", keyType)
 	}
 
-	// 2) PEM-encode private key
-	privDER := x509.MarshalPKCS1PrivateKey(key)
-	privBlock := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: privDER}
+	privBlock, err := ssh.MarshalPrivateKey(signerKey, fmt.Sprintf("rancher-%s", d.MachineName))
+	if err != nil {
+		return nil, fmt.Errorf("marshaling private key: %w", err)
+	}
 	privBytes := pem.EncodeToMemory(privBlock)
 
-	// 3) Create OpenSSH public key
-	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	pub, err := ssh.NewPublicKey(publicKeyOf(signerKey))
 	if err != nil {
 		return nil, fmt.Errorf("creating public key: %w", err)
 	}
 	pubBytes := ssh.MarshalAuthorizedKey(pub)
 
-	// 4) Write private key to disk under d.StorePath
-	fileName := fmt.Sprintf("%s_id_rsa", d.MachineName) // unique per machine
-	keyPath := filepath.Join(d.StorePath, fileName+"")  // e.g. /.../mymachine_id_rsa
+	// Write private key to disk under d.StorePath, named after the actual
+	// key type so a store directory listing isn't misleading for anything
+	// other than RSA.
+	keyPath := localSSHKeyPath(d.StorePath, d.MachineName, keyType)
 	if err := os.MkdirAll(d.StorePath, 0700); err != nil {
 		return nil, fmt.Errorf("creating store directory: %w", err)
 	}
@@ -53,3 +83,36 @@ func (d *Driver) generateSSHKey() ([]byte, error) {
 
 	return pubBytes, nil
 }
+
+// localSSHKeyPath returns the deterministic path generateSSHKey writes a
+// freshly generated private key to, so it can be re-derived later without
+// regenerating the key, e.g. to recover d.SSHKeyPath when FindKey adopts an
+// existing SSHKeyID and resolveSSHKey never runs.
+func localSSHKeyPath(storePath, machineName, keyType string) string {
+	resolvedKeyType := keyType
+	if resolvedKeyType == "" {
+		resolvedKeyType = sshKeyTypeRSA
+	}
+	return filepath.Join(storePath, fmt.Sprintf("%s_id_%s", machineName, resolvedKeyType))
+}
+
+// fileExists reports whether path is a regular file.
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// publicKeyOf returns the public half of an RSA, ECDSA, or ed25519 private
+// key, for use with ssh.NewPublicKey.
+func publicKeyOf(key interface{}) interface{} {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	default:
+		return nil
+	}
+}
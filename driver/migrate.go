@@ -0,0 +1,71 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// UnmarshalJSON lets a persisted config.json from the pre-hcloud-go-v2
+// driver keep working. ServerID and SSHKeyID are int64 today (hcloud-go v2
+// server/key IDs can exceed 32 bits), but older state may have them as a
+// plain (32-bit-range) int, or, as some tooling re-serialized them, a
+// quoted numeric string; both still decode correctly here.
+func (d *Driver) UnmarshalJSON(data []byte) error {
+	type driverAlias Driver
+	aux := &struct {
+		ServerID json.RawMessage `json:"ServerID"`
+		SSHKeyID json.RawMessage `json:"SSHKeyID"`
+		*driverAlias
+	}{driverAlias: (*driverAlias)(d)}
+
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+
+	serverID, err := decodeLegacyID("ServerID", aux.ServerID)
+	if err != nil {
+		return err
+	}
+	d.ServerID = serverID
+
+	sshKeyID, err := decodeLegacyID("SSHKeyID", aux.SSHKeyID)
+	if err != nil {
+		return err
+	}
+	d.SSHKeyID = sshKeyID
+
+	return nil
+}
+
+// decodeLegacyID parses an int64 ID field that may be absent, a JSON
+// number (the pre-v2 and current wire formats), or a quoted numeric string
+// (what some older tooling emitted instead).
+func decodeLegacyID(field string, raw json.RawMessage) (int64, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+
+	var asInt int64
+	if err := json.Unmarshal(raw, &asInt); err == nil {
+		return asInt, nil
+	}
+
+	var asString string
+	if err := json.Unmarshal(raw, &asString); err != nil {
+		return 0, fmt.Errorf("%s has unsupported JSON type %s", field, raw)
+	}
+	asString = strings.TrimSpace(asString)
+	if asString == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(asString, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q is not a valid int64: %w", field, asString, err)
+	}
+	log.Warnf("%s was persisted as a string (%q); migrating it to int64", field, asString)
+	return id, nil
+}
@@ -0,0 +1,46 @@
+package driver
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// legacyConfigFixture mimics a config.json persisted by the pre-hcloud-go-v2
+// driver: ServerID as a quoted numeric string (as some tooling re-saved
+// it) and SSHKeyID as a plain JSON number.
+const legacyConfigFixture = `{
+	"MachineName": "legacy-node",
+	"StorePath": "/root/.docker/machine/machines/legacy-node",
+	"APIToken": "secret",
+	"ServerType": "cx11",
+	"Image": "ubuntu-20.04",
+	"Region": "nbg1",
+	"ServerID": "1234567890123",
+	"SSHKeyID": 987654
+}`
+
+func TestUnmarshalJSONMigratesLegacyIDs(t *testing.T) {
+	var d Driver
+	if err := json.Unmarshal([]byte(legacyConfigFixture), &d); err != nil {
+		t.Fatalf("unmarshal legacy config.json: %v", err)
+	}
+	if d.ServerID != 1234567890123 {
+		t.Errorf("ServerID = %d, want 1234567890123", d.ServerID)
+	}
+	if d.SSHKeyID != 987654 {
+		t.Errorf("SSHKeyID = %d, want 987654", d.SSHKeyID)
+	}
+	if d.MachineName != "legacy-node" {
+		t.Errorf("MachineName = %q, want %q", d.MachineName, "legacy-node")
+	}
+}
+
+func TestUnmarshalJSONAbsentIDs(t *testing.T) {
+	var d Driver
+	if err := json.Unmarshal([]byte(`{"MachineName": "fresh-node"}`), &d); err != nil {
+		t.Fatalf("unmarshal config.json with no IDs yet: %v", err)
+	}
+	if d.ServerID != 0 || d.SSHKeyID != 0 {
+		t.Errorf("expected zero IDs, got ServerID=%d SSHKeyID=%d", d.ServerID, d.SSHKeyID)
+	}
+}
@@ -0,0 +1,41 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseLabels turns a list of "key=value" --hetzner-label entries into a
+// map, as required by providers.CreateOpts.Labels.
+func parseLabels(raw []string) (map[string]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(raw))
+	for _, entry := range raw {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --hetzner-label entry %q: expected key=value", entry)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// loadUserData resolves --hetzner-user-data: if it names a file that exists
+// on disk, its contents are used verbatim; otherwise the flag value itself
+// is treated as inline cloud-init content.
+func loadUserData(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	if !fileExists(raw) {
+		return raw, nil
+	}
+	data, err := os.ReadFile(raw)
+	if err != nil {
+		return "", fmt.Errorf("reading user-data file %q: %w", raw, err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,73 @@
+package driver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// sshDialInterval and cloudInitPollInterval are the backoff between retries
+// of waitForSSH and waitForCloudInit, respectively. defaultWaitTimeout is
+// used when --hetzner-wait-timeout isn't set.
+const (
+	sshDialInterval       = 3 * time.Second
+	cloudInitPollInterval = 5 * time.Second
+	defaultWaitTimeout    = 5 * time.Minute
+)
+
+// waitForSSH blocks until the server at d.IPAddress accepts TCP connections
+// on port 22 and will actually run a command over SSH, or until timeout
+// elapses. Rancher starts SSHing in as soon as Create() returns, which is
+// often before sshd (or cloud-init) has finished starting.
+func (d *Driver) waitForSSH(timeout time.Duration) error {
+	addr := net.JoinHostPort(d.IPAddress, "22")
+	deadline := time.Now().Add(timeout)
+
+	log.Infof("Waiting for SSH to be available on %s...", addr)
+	for {
+		if conn, err := net.DialTimeout("tcp", addr, sshDialInterval); err != nil {
+			log.Debugf("SSH not yet reachable on %s: %v", addr, err)
+		} else {
+			conn.Close()
+			if _, err := drivers.RunSSHCommandFromDriver(d, "exit 0"); err == nil {
+				log.Infof("SSH is available on %s", addr)
+				return nil
+			} else {
+				log.Debugf("SSH reachable on %s but command failed, retrying: %v", addr, err)
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for SSH on %s", timeout, addr)
+		}
+		time.Sleep(sshDialInterval)
+	}
+}
+
+// waitForCloudInit blocks until `cloud-init status --wait` reports the
+// server has finished booting, or until timeout elapses. Only called when
+// --hetzner-wait-for-cloud-init is set and user-data was actually supplied.
+func (d *Driver) waitForCloudInit(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	log.Infof("Waiting for cloud-init to finish on %s...", d.IPAddress)
+	for {
+		output, err := drivers.RunSSHCommandFromDriver(d, "cloud-init status --wait")
+		if err == nil && strings.Contains(output, "done") {
+			log.Infof("cloud-init finished on %s", d.IPAddress)
+			return nil
+		}
+		if err != nil {
+			log.Debugf("cloud-init status check on %s failed, retrying: %v", d.IPAddress, err)
+		} else {
+			log.Debugf("cloud-init not done yet on %s: %s", d.IPAddress, strings.TrimSpace(output))
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for cloud-init on %s", timeout, d.IPAddress)
+		}
+		time.Sleep(cloudInitPollInterval)
+	}
+}
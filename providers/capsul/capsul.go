@@ -0,0 +1,68 @@
+// Package capsul will implement providers.Provider against Capsul, the
+// small-VPS host abra grew a ServerProvider backend for alongside Hetzner.
+// Not implemented yet; this stub exists so --server-provider=capsul fails
+// with a clear error instead of an unknown flag value.
+package capsul
+
+import (
+	"fmt"
+
+	"github.com/rancher/machine/libmachine/state"
+
+	"github.com/TheRideru/rancher-hcloud-driver/providers"
+)
+
+// Provider is an unimplemented providers.Provider for Capsul.
+type Provider struct{}
+
+// New returns a Provider for Capsul. apiToken is ignored, since every method
+// returns errNotImplemented.
+func New(apiToken string) *Provider {
+	return &Provider{}
+}
+
+var errNotImplemented = fmt.Errorf("--server-provider=capsul is not implemented yet")
+
+func (p *Provider) UploadKey(keyName, existingKeyRef string, publicKey []byte, storePath string) (int64, bool, error) {
+	return 0, false, errNotImplemented
+}
+
+func (p *Provider) DeleteKey(keyID int64) error {
+	return errNotImplemented
+}
+
+func (p *Provider) CreateServer(opts providers.CreateOpts) (providers.CreateResult, error) {
+	return providers.CreateResult{}, errNotImplemented
+}
+
+func (p *Provider) DeleteServer(serverID int64, createdVolumeIDs []int64) error {
+	return errNotImplemented
+}
+
+func (p *Provider) FindServer(machineName, storePath string) (int64, string, bool, error) {
+	return 0, "", false, errNotImplemented
+}
+
+func (p *Provider) FindKey(machineName, storePath string) (int64, bool, error) {
+	return 0, false, errNotImplemented
+}
+
+func (p *Provider) FindVolumes(machineName, storePath string) ([]int64, error) {
+	return nil, errNotImplemented
+}
+
+func (p *Provider) PowerOn(serverID int64) error {
+	return errNotImplemented
+}
+
+func (p *Provider) PowerOff(serverID int64) error {
+	return errNotImplemented
+}
+
+func (p *Provider) Reboot(serverID int64) error {
+	return errNotImplemented
+}
+
+func (p *Provider) GetStatus(serverID int64) (state.State, error) {
+	return state.Error, errNotImplemented
+}
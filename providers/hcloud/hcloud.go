@@ -0,0 +1,595 @@
+// Package hcloud implements providers.Provider against Hetzner Cloud. This
+// is the driver's original (and default) backend; it was factored out of
+// the driver package so a --server-provider flag can select between it and
+// other backends without the driver package knowing any hcloud-go types.
+package hcloud
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	hcloudgo "github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/state"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/TheRideru/rancher-hcloud-driver/providers"
+)
+
+// ownerLabel is attached to every Hetzner resource this provider creates on
+// its own (the server, a freshly generated SSH key, and any volumes it
+// creates), marking them as created by this driver rather than pre-existing
+// resources it was only asked to attach. No code here reads it back:
+// DeleteServer deletes exactly the createdVolumeIDs it is given.
+//
+// machineLabel and storePathHashLabel are additionally stamped on the
+// server itself so FindServer can recover it by a label selector alone,
+// making Create()/Remove() idempotent across an interrupted run.
+// createdAtLabel just records when, for operator debugging.
+const (
+	ownerLabel         = "rancher-driver"
+	machineLabel       = "rancher-machine"
+	storePathHashLabel = "rancher-store-path-hash"
+	createdAtLabel     = "rancher-created-at"
+)
+
+// Provider talks to the Hetzner Cloud API.
+type Provider struct {
+	client *hcloudgo.Client
+}
+
+// New returns a Provider authenticated with the given Hetzner Cloud API
+// token.
+func New(apiToken string) *Provider {
+	return &Provider{client: hcloudgo.NewClient(hcloudgo.WithToken(apiToken))}
+}
+
+// UploadKey resolves or uploads the SSH key to attach to a new server. See
+// providers.Provider for the created/existingKeyRef contract.
+func (p *Provider) UploadKey(keyName, existingKeyRef string, publicKey []byte, storePath string) (int64, bool, error) {
+	ctx := context.Background()
+
+	if existingKeyRef == "" {
+		// Recovering a key from an interrupted prior Create() run is
+		// FindKey's job (a label-scoped lookup driver.Create() already does
+		// before calling UploadKey); adopting one here by name alone, with
+		// no fingerprint check that it actually matches the freshly
+		// generated publicKey, risked attaching a server to a private key
+		// that wasn't the one on disk. Always upload what was generated.
+		hkey, _, err := p.client.SSHKey.Create(ctx, hcloudgo.SSHKeyCreateOpts{
+			Name:      keyName,
+			PublicKey: string(publicKey),
+			Labels:    keyLabels(keyName, storePath),
+		})
+		if err != nil {
+			return 0, false, fmt.Errorf("creating SSH key in Hetzner Cloud: %w", err)
+		}
+		return hkey.ID, true, nil
+	}
+
+	key, err := p.resolveExistingSSHKey(ctx, keyName, existingKeyRef)
+	if err != nil {
+		return 0, false, err
+	}
+	return key.ID, false, nil
+}
+
+// resolveExistingSSHKey resolves --hetzner-existing-ssh-key. A value that
+// names a file on disk is treated as a local private key: its SHA256
+// fingerprint is computed, as fleeting-plugin-hetzner's sshutil does, and
+// used to find the matching key already uploaded to Hetzner Cloud, uploading
+// it only if it isn't there yet. Any other value is looked up directly by
+// name or ID.
+func (p *Provider) resolveExistingSSHKey(ctx context.Context, keyName, ref string) (*hcloudgo.SSHKey, error) {
+	info, statErr := os.Stat(ref)
+	if statErr != nil || info.IsDir() {
+		key, _, err := p.client.SSHKey.Get(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("looking up SSH key %q: %w", ref, err)
+		}
+		if key == nil {
+			return nil, fmt.Errorf("SSH key %q not found", ref)
+		}
+		return key, nil
+	}
+
+	privBytes, err := os.ReadFile(ref)
+	if err != nil {
+		return nil, fmt.Errorf("reading private key %q: %w", ref, err)
+	}
+	signer, err := ssh.ParsePrivateKey(privBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key %q: %w", ref, err)
+	}
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+
+	keys, err := p.client.SSHKey.AllWithOpts(ctx, hcloudgo.SSHKeyListOpts{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, fmt.Errorf("looking up SSH key by fingerprint %q: %w", fingerprint, err)
+	}
+	if len(keys) > 0 {
+		return keys[0], nil
+	}
+
+	hkey, _, err := p.client.SSHKey.Create(ctx, hcloudgo.SSHKeyCreateOpts{
+		Name:      keyName,
+		PublicKey: string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		Labels:    map[string]string{ownerLabel: keyName},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("uploading existing SSH key to Hetzner Cloud: %w", err)
+	}
+	return hkey, nil
+}
+
+// DeleteKey removes an SSH key this provider uploaded.
+func (p *Provider) DeleteKey(keyID int64) error {
+	ctx := context.Background()
+	if _, err := p.client.SSHKey.Delete(ctx, &hcloudgo.SSHKey{ID: keyID}); err != nil {
+		return fmt.Errorf("deleting SSH key %d: %w", keyID, err)
+	}
+	return nil
+}
+
+// CreateServer provisions a Hetzner Cloud server per opts.
+func (p *Provider) CreateServer(opts providers.CreateOpts) (providers.CreateResult, error) {
+	ctx := context.Background()
+
+	networks, err := p.resolveNetworks(ctx, opts.Networks)
+	if err != nil {
+		return providers.CreateResult{}, err
+	}
+	firewalls, err := p.resolveFirewalls(ctx, opts.Firewalls)
+	if err != nil {
+		return providers.CreateResult{}, err
+	}
+	extraKeys, err := p.resolveExtraSSHKeys(ctx, opts.SSHKeys)
+	if err != nil {
+		return providers.CreateResult{}, err
+	}
+	placementGroup, err := p.resolvePlacementGroup(ctx, opts.PlacementGroup)
+	if err != nil {
+		return providers.CreateResult{}, err
+	}
+	volumeSpecs, err := parseVolumeSpecs(opts.Volumes)
+	if err != nil {
+		return providers.CreateResult{}, err
+	}
+	attachVolumes, err := p.resolveAttachVolumes(ctx, volumeSpecs)
+	if err != nil {
+		return providers.CreateResult{}, err
+	}
+
+	sshKeys := make([]*hcloudgo.SSHKey, 0, len(extraKeys)+1)
+	if opts.KeyID != 0 {
+		sshKeys = append(sshKeys, &hcloudgo.SSHKey{ID: opts.KeyID})
+	}
+	sshKeys = append(sshKeys, extraKeys...)
+
+	createResult, _, err := p.client.Server.Create(ctx, hcloudgo.ServerCreateOpts{
+		Name:           opts.MachineName,
+		ServerType:     &hcloudgo.ServerType{Name: opts.ServerType},
+		Image:          &hcloudgo.Image{Name: opts.Image},
+		Location:       &hcloudgo.Location{Name: opts.Region},
+		SSHKeys:        sshKeys,
+		Networks:       networks,
+		Firewalls:      firewalls,
+		Volumes:        attachVolumes,
+		PlacementGroup: placementGroup,
+		Labels:         serverLabels(opts),
+		UserData:       opts.UserData,
+	})
+	if err != nil {
+		return providers.CreateResult{}, fmt.Errorf("error creating Hetzner server: %w", err)
+	}
+	server := createResult.Server
+
+	if createResult.Action != nil {
+		if err := p.client.Action.WaitForFunc(ctx,
+			func(a *hcloudgo.Action) error { return nil },
+			createResult.Action,
+		); err != nil {
+			return providers.CreateResult{}, fmt.Errorf("waiting for server creation: %w", err)
+		}
+	}
+
+	ipAddress, err := p.waitForPublicIPv4(ctx, server.ID)
+	if err != nil {
+		return providers.CreateResult{}, err
+	}
+
+	createdVolumeIDs, err := p.createVolumes(ctx, volumeSpecs, server, opts.MachineName, opts.StorePath)
+	if err != nil {
+		return providers.CreateResult{}, err
+	}
+
+	return providers.CreateResult{
+		ServerID:         server.ID,
+		IPAddress:        ipAddress,
+		CreatedVolumeIDs: createdVolumeIDs,
+	}, nil
+}
+
+// waitForPublicIPv4 polls the server until it has a public IPv4 address.
+func (p *Provider) waitForPublicIPv4(ctx context.Context, serverID int64) (string, error) {
+	var srv *hcloudgo.Server
+	var err error
+	for i := 0; i < 30; i++ {
+		srv, _, err = p.client.Server.GetByID(ctx, serverID)
+		if err != nil {
+			return "", fmt.Errorf("fetching server %d: %w", serverID, err)
+		}
+		if srv.PublicNet.IPv4.IP != nil {
+			return srv.PublicNet.IPv4.IP.String(), nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return "", fmt.Errorf("server %d has no public IPv4 after timeout", serverID)
+}
+
+// DeleteServer deletes the server and any volumes this driver created for
+// it; volumes it only attached are left for their owner.
+func (p *Provider) DeleteServer(serverID int64, createdVolumeIDs []int64) error {
+	ctx := context.Background()
+
+	delRes, _, err := p.client.Server.DeleteWithResult(ctx, &hcloudgo.Server{ID: serverID})
+	if err != nil {
+		return fmt.Errorf("deleting server %d: %w", serverID, err)
+	}
+	if delRes.Action != nil {
+		if err := p.client.Action.WaitForFunc(ctx,
+			func(a *hcloudgo.Action) error { return nil },
+			delRes.Action,
+		); err != nil {
+			return fmt.Errorf("waiting for server deletion: %w", err)
+		}
+	}
+
+	for _, volumeID := range createdVolumeIDs {
+		if _, err := p.client.Volume.Delete(ctx, &hcloudgo.Volume{ID: volumeID}); err != nil {
+			return fmt.Errorf("deleting volume %d: %w", volumeID, err)
+		}
+	}
+	return nil
+}
+
+// PowerOn powers on the server.
+func (p *Provider) PowerOn(serverID int64) error {
+	ctx := context.Background()
+	srv, _, err := p.client.Server.GetByID(ctx, serverID)
+	if err != nil {
+		return fmt.Errorf("cannot fetch server %d: %w", serverID, err)
+	}
+	if srv == nil {
+		return nil
+	}
+	if _, _, err := p.client.Server.Poweron(ctx, srv); err != nil {
+		return fmt.Errorf("powering on server %d: %w", serverID, err)
+	}
+	return nil
+}
+
+// PowerOff powers off the server.
+func (p *Provider) PowerOff(serverID int64) error {
+	ctx := context.Background()
+	srv, _, err := p.client.Server.GetByID(ctx, serverID)
+	if err != nil {
+		return fmt.Errorf("cannot fetch server %d: %w", serverID, err)
+	}
+	if srv == nil {
+		return nil
+	}
+	if _, _, err := p.client.Server.Poweroff(ctx, srv); err != nil {
+		return fmt.Errorf("powering off server %d: %w", serverID, err)
+	}
+	return nil
+}
+
+// Reboot reboots the server.
+func (p *Provider) Reboot(serverID int64) error {
+	ctx := context.Background()
+	srv, _, err := p.client.Server.GetByID(ctx, serverID)
+	if err != nil {
+		return fmt.Errorf("cannot fetch server %d: %w", serverID, err)
+	}
+	if srv == nil {
+		return nil
+	}
+	if _, _, err := p.client.Server.Reboot(ctx, srv); err != nil {
+		return fmt.Errorf("rebooting server %d: %w", serverID, err)
+	}
+	return nil
+}
+
+// GetStatus queries Hetzner for the server status (Running, Stopped, etc.)
+func (p *Provider) GetStatus(serverID int64) (state.State, error) {
+	ctx := context.Background()
+	srv, _, err := p.client.Server.GetByID(ctx, serverID)
+	if err != nil {
+		return state.Error, fmt.Errorf("fetching server %d: %w", serverID, err)
+	}
+	switch srv.Status {
+	case hcloudgo.ServerStatusRunning:
+		return state.Running, nil
+	case hcloudgo.ServerStatusOff:
+		return state.Stopped, nil
+	default:
+		return state.None, nil
+	}
+}
+
+// volumeSpec is one entry of --hetzner-volume, either a reference to an
+// existing volume to attach or a request to create a new one.
+type volumeSpec struct {
+	name   string
+	sizeGB int // 0 means "attach existing", >0 means "create new"
+}
+
+func parseVolumeSpecs(raw []string) ([]volumeSpec, error) {
+	specs := make([]volumeSpec, 0, len(raw))
+	for _, entry := range raw {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, sizeStr, hasSize := strings.Cut(entry, ":")
+		if !hasSize {
+			specs = append(specs, volumeSpec{name: name})
+			continue
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(sizeStr))
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid --hetzner-volume entry %q: size must be a positive integer number of GB", entry)
+		}
+		specs = append(specs, volumeSpec{name: name, sizeGB: size})
+	}
+	return specs, nil
+}
+
+// resolveNetworks looks up each --hetzner-network entry (by name or ID).
+// All of them are expected to already exist.
+func (p *Provider) resolveNetworks(ctx context.Context, refs []string) ([]*hcloudgo.Network, error) {
+	networks := make([]*hcloudgo.Network, 0, len(refs))
+	for _, ref := range refs {
+		network, _, err := p.client.Network.Get(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("looking up network %q: %w", ref, err)
+		}
+		if network == nil {
+			return nil, fmt.Errorf("network %q not found", ref)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// resolveFirewalls looks up each --hetzner-firewall entry (by name or ID).
+func (p *Provider) resolveFirewalls(ctx context.Context, refs []string) ([]*hcloudgo.ServerCreateFirewall, error) {
+	firewalls := make([]*hcloudgo.ServerCreateFirewall, 0, len(refs))
+	for _, ref := range refs {
+		firewall, _, err := p.client.Firewall.Get(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("looking up firewall %q: %w", ref, err)
+		}
+		if firewall == nil {
+			return nil, fmt.Errorf("firewall %q not found", ref)
+		}
+		firewalls = append(firewalls, &hcloudgo.ServerCreateFirewall{Firewall: *firewall})
+	}
+	return firewalls, nil
+}
+
+// resolveExtraSSHKeys looks up each --hetzner-ssh-key entry (by name or ID).
+// These are attached in addition to the server's primary key.
+func (p *Provider) resolveExtraSSHKeys(ctx context.Context, refs []string) ([]*hcloudgo.SSHKey, error) {
+	keys := make([]*hcloudgo.SSHKey, 0, len(refs))
+	for _, ref := range refs {
+		key, _, err := p.client.SSHKey.Get(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("looking up SSH key %q: %w", ref, err)
+		}
+		if key == nil {
+			return nil, fmt.Errorf("SSH key %q not found", ref)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// resolvePlacementGroup looks up --hetzner-placement-group (by name or ID),
+// if set.
+func (p *Provider) resolvePlacementGroup(ctx context.Context, ref string) (*hcloudgo.PlacementGroup, error) {
+	if ref == "" {
+		return nil, nil
+	}
+	group, _, err := p.client.PlacementGroup.Get(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("looking up placement group %q: %w", ref, err)
+	}
+	if group == nil {
+		return nil, fmt.Errorf("placement group %q not found", ref)
+	}
+	return group, nil
+}
+
+// resolveAttachVolumes looks up the --hetzner-volume entries that reference
+// an existing volume (no :<size> suffix) so they can be attached at create
+// time. Entries that request a new volume are handled separately by
+// createVolumes, once the server exists.
+func (p *Provider) resolveAttachVolumes(ctx context.Context, specs []volumeSpec) ([]*hcloudgo.Volume, error) {
+	volumes := make([]*hcloudgo.Volume, 0, len(specs))
+	for _, spec := range specs {
+		if spec.sizeGB > 0 {
+			continue
+		}
+		volume, _, err := p.client.Volume.Get(ctx, spec.name)
+		if err != nil {
+			return nil, fmt.Errorf("looking up volume %q: %w", spec.name, err)
+		}
+		if volume == nil {
+			return nil, fmt.Errorf("volume %q not found", spec.name)
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, nil
+}
+
+// createVolumes provisions the --hetzner-volume entries that requested a new
+// volume (with a :<size> suffix), attaching each to the server as it is
+// created. The resulting IDs are returned so the caller can record them for
+// later cleanup; volumes that were only attached (pre-existing) are left
+// for their owner. Each created volume gets the same machineLabel/
+// storePathHashLabel pair as the server (see volumeLabels) so FindVolumes
+// can recover it if Remove() runs with a lost CreatedVolumeIDs.
+func (p *Provider) createVolumes(ctx context.Context, specs []volumeSpec, server *hcloudgo.Server, machineName, storePath string) ([]int64, error) {
+	var createdVolumeIDs []int64
+	for _, spec := range specs {
+		if spec.sizeGB == 0 {
+			continue
+		}
+		result, _, err := p.client.Volume.Create(ctx, hcloudgo.VolumeCreateOpts{
+			Name:   spec.name,
+			Size:   spec.sizeGB,
+			Server: server,
+			Labels: volumeLabels(machineName, storePath),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating volume %q: %w", spec.name, err)
+		}
+		if result.Action != nil {
+			if err := p.client.Action.WaitForFunc(ctx, func(a *hcloudgo.Action) error { return nil }, result.Action); err != nil {
+				return nil, fmt.Errorf("waiting for volume %q creation: %w", spec.name, err)
+			}
+		}
+		createdVolumeIDs = append(createdVolumeIDs, result.Volume.ID)
+	}
+	return createdVolumeIDs, nil
+}
+
+// mergedLabels returns the user-supplied --hetzner-label set plus the
+// ownerLabel that marks a resource as owned by this driver.
+func mergedLabels(labels map[string]string, machineName string) map[string]string {
+	merged := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		merged[k] = v
+	}
+	merged[ownerLabel] = machineName
+	return merged
+}
+
+// serverLabels returns mergedLabels plus the discovery labels FindServer
+// relies on: machineLabel so the server can be found by machine name,
+// storePathHashLabel so that lookup is scoped to this machine's local store
+// (two machines sharing a name across different stores/clusters must not be
+// confused for each other), and createdAtLabel for operator debugging.
+func serverLabels(opts providers.CreateOpts) map[string]string {
+	labels := mergedLabels(opts.Labels, opts.MachineName)
+	labels[machineLabel] = opts.MachineName
+	labels[storePathHashLabel] = storePathHash(opts.StorePath)
+	labels[createdAtLabel] = time.Now().UTC().Format("20060102T150405Z")
+	return labels
+}
+
+// keyLabels returns the labels stamped on a freshly generated SSH key, the
+// same machineLabel/storePathHashLabel pair serverLabels stamps on its
+// server, so FindKey can be scoped to this machine's local store exactly
+// like FindServer.
+func keyLabels(keyName, storePath string) map[string]string {
+	return map[string]string{
+		ownerLabel:         keyName,
+		machineLabel:       keyName,
+		storePathHashLabel: storePathHash(storePath),
+	}
+}
+
+// volumeLabels returns the labels stamped on a freshly created volume, the
+// same machineLabel/storePathHashLabel pair serverLabels stamps on its
+// server, so FindVolumes can recover it the same way FindServer/FindKey do.
+func volumeLabels(machineName, storePath string) map[string]string {
+	return map[string]string{
+		ownerLabel:         machineName,
+		machineLabel:       machineName,
+		storePathHashLabel: storePathHash(storePath),
+	}
+}
+
+// discoverySelector is the label selector FindServer, FindKey, and
+// FindVolumes use to recover a resource this provider previously created for
+// machineName, scoped to storePath so two machines sharing a name across
+// different local stores/clusters can't be confused for each other.
+func discoverySelector(machineName, storePath string) string {
+	return fmt.Sprintf("%s=%s,%s=%s", machineLabel, machineName, storePathHashLabel, storePathHash(storePath))
+}
+
+// storePathHash returns a short, label-value-safe fingerprint of a local
+// docker-machine store path.
+func storePathHash(storePath string) string {
+	sum := sha256.Sum256([]byte(storePath))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// FindServer looks up a server this provider previously created for
+// machineName, via the machineLabel/storePathHashLabel pair it stamps in
+// CreateServer. Both must match: two machines can share a name across
+// different local stores/clusters, and must not be confused for each other.
+func (p *Provider) FindServer(machineName, storePath string) (int64, string, bool, error) {
+	ctx := context.Background()
+	servers, err := p.client.Server.AllWithOpts(ctx, hcloudgo.ServerListOpts{
+		ListOpts: hcloudgo.ListOpts{LabelSelector: discoverySelector(machineName, storePath)},
+	})
+	if err != nil {
+		return 0, "", false, fmt.Errorf("looking up existing server for %q: %w", machineName, err)
+	}
+	if len(servers) == 0 {
+		return 0, "", false, nil
+	}
+	srv := servers[0]
+	var ipAddress string
+	if srv.PublicNet.IPv4.IP != nil {
+		ipAddress = srv.PublicNet.IPv4.IP.String()
+	}
+	return srv.ID, ipAddress, true, nil
+}
+
+// FindKey looks up an SSH key this provider previously uploaded for
+// machineName, via the same machineLabel/storePathHashLabel pair UploadKey
+// stamps on it (see keyLabels), scoped the same way as FindServer.
+func (p *Provider) FindKey(machineName, storePath string) (int64, bool, error) {
+	ctx := context.Background()
+	keyName := fmt.Sprintf("rancher-%s", machineName)
+	keys, err := p.client.SSHKey.AllWithOpts(ctx, hcloudgo.SSHKeyListOpts{
+		ListOpts: hcloudgo.ListOpts{LabelSelector: discoverySelector(keyName, storePath)},
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("looking up SSH key for %q: %w", machineName, err)
+	}
+	if len(keys) == 0 {
+		return 0, false, nil
+	}
+	return keys[0].ID, true, nil
+}
+
+// FindVolumes looks up volumes this provider previously created for
+// machineName, via the same machineLabel/storePathHashLabel pair
+// createVolumes stamps on them (see volumeLabels), scoped the same way as
+// FindServer/FindKey. Used by Remove() to clean up volumes from a Create()
+// that crashed before CreatedVolumeIDs was persisted.
+func (p *Provider) FindVolumes(machineName, storePath string) ([]int64, error) {
+	ctx := context.Background()
+	volumes, err := p.client.Volume.AllWithOpts(ctx, hcloudgo.VolumeListOpts{
+		ListOpts: hcloudgo.ListOpts{LabelSelector: discoverySelector(machineName, storePath)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("looking up existing volumes for %q: %w", machineName, err)
+	}
+	ids := make([]int64, 0, len(volumes))
+	for _, v := range volumes {
+		ids = append(ids, v.ID)
+	}
+	return ids, nil
+}
@@ -0,0 +1,76 @@
+// Package hrobot will implement providers.Provider against Hetzner Robot,
+// Hetzner's API for dedicated (non-Cloud) servers. Unlike hcloud, Robot has
+// no server-create call: provisioning a dedicated box means ordering it (or
+// picking one already racked) and driving its rescue system over SSH to lay
+// down an OS, which is a meaningfully different Create() flow from the rest
+// of this package.
+//
+// That flow is not implemented here. This package is a stub, identical in
+// shape to providers/capsul: every method just returns errNotImplemented, so
+// --server-provider=hrobot fails with a clear error instead of an unknown
+// flag value. No dedicated server can be provisioned or managed through it.
+package hrobot
+
+import (
+	"fmt"
+
+	"github.com/rancher/machine/libmachine/state"
+
+	"github.com/TheRideru/rancher-hcloud-driver/providers"
+)
+
+// Provider is an unimplemented providers.Provider for Hetzner Robot. See the
+// package doc comment: this is a stub with no rescue-mode install flow.
+type Provider struct{}
+
+// New returns a Provider for Hetzner Robot. apiToken is ignored, since every
+// method returns errNotImplemented.
+func New(apiToken string) *Provider {
+	return &Provider{}
+}
+
+var errNotImplemented = fmt.Errorf("--server-provider=hrobot is not implemented yet")
+
+func (p *Provider) UploadKey(keyName, existingKeyRef string, publicKey []byte, storePath string) (int64, bool, error) {
+	return 0, false, errNotImplemented
+}
+
+func (p *Provider) DeleteKey(keyID int64) error {
+	return errNotImplemented
+}
+
+func (p *Provider) CreateServer(opts providers.CreateOpts) (providers.CreateResult, error) {
+	return providers.CreateResult{}, errNotImplemented
+}
+
+func (p *Provider) DeleteServer(serverID int64, createdVolumeIDs []int64) error {
+	return errNotImplemented
+}
+
+func (p *Provider) FindServer(machineName, storePath string) (int64, string, bool, error) {
+	return 0, "", false, errNotImplemented
+}
+
+func (p *Provider) FindKey(machineName, storePath string) (int64, bool, error) {
+	return 0, false, errNotImplemented
+}
+
+func (p *Provider) FindVolumes(machineName, storePath string) ([]int64, error) {
+	return nil, errNotImplemented
+}
+
+func (p *Provider) PowerOn(serverID int64) error {
+	return errNotImplemented
+}
+
+func (p *Provider) PowerOff(serverID int64) error {
+	return errNotImplemented
+}
+
+func (p *Provider) Reboot(serverID int64) error {
+	return errNotImplemented
+}
+
+func (p *Provider) GetStatus(serverID int64) (state.State, error) {
+	return state.Error, errNotImplemented
+}
@@ -0,0 +1,90 @@
+// Package providers defines the Provider interface that driver.Driver
+// delegates instance lifecycle to, so one driver binary can provision
+// servers from more than one backend (Hetzner Cloud, Hetzner Robot,
+// community providers) without the rest of the driver caring which.
+package providers
+
+import (
+	"github.com/rancher/machine/libmachine/state"
+)
+
+// CreateOpts carries everything a Provider needs to provision a new server.
+// Fields a given backend doesn't support (e.g. PlacementGroup on a provider
+// with no such concept) are simply ignored.
+type CreateOpts struct {
+	MachineName    string
+	StorePath      string // local docker-machine store path; providers may fold this into a discovery label
+	ServerType     string
+	Image          string
+	Region         string
+	Networks       []string          // names or IDs of existing private networks to attach
+	Firewalls      []string          // names or IDs of existing firewalls to apply
+	SSHKeys        []string          // names or IDs of existing SSH keys to attach, in addition to KeyID
+	KeyID          int64             // ID of the key returned by a prior UploadKey call, attached as the primary key
+	Volumes        []string          // "<name>:<size-gb>" to create a volume, or "<name-or-id>" to attach an existing one
+	PlacementGroup string            // name or ID of an existing placement group
+	Labels         map[string]string // extra labels merged onto the new server's labels
+	UserData       string            // cloud-init content
+}
+
+// CreateResult reports what CreateServer actually provisioned, so Driver can
+// persist it for later Remove()/GetState() calls.
+type CreateResult struct {
+	ServerID         int64
+	IPAddress        string
+	CreatedVolumeIDs []int64 // IDs of volumes this call created, as opposed to merely attached
+}
+
+// Provider is implemented by each supported server backend. Driver holds
+// only enough state (APIToken, ServerProvider, ServerID, ...) to construct
+// one and delegate to it; none of the backend-specific API types leak out
+// of this package.
+type Provider interface {
+	// UploadKey resolves the SSH key to use for a new server. If
+	// existingKeyRef is empty, publicKey is uploaded under keyName,
+	// stamped with the same discovery labels as storePath would give a
+	// server (see FindKey), and created is true, meaning the caller owns
+	// it and should DeleteKey it on teardown. If existingKeyRef is set (a
+	// name, ID, or local private key path understood by the backend), the
+	// matching key already on the backend is reused, uploading it only if
+	// it's missing there; created is always false in that case, since
+	// teardown must not delete a key the caller doesn't own.
+	UploadKey(keyName, existingKeyRef string, publicKey []byte, storePath string) (keyID int64, created bool, err error)
+
+	// DeleteKey removes a key previously returned by UploadKey with
+	// created == true.
+	DeleteKey(keyID int64) error
+
+	// CreateServer provisions a new server per opts.
+	CreateServer(opts CreateOpts) (CreateResult, error)
+
+	// DeleteServer tears down the server and any volumes this driver
+	// created for it (createdVolumeIDs, as returned by CreateServer);
+	// volumes it only attached are left alone.
+	DeleteServer(serverID int64, createdVolumeIDs []int64) error
+
+	// FindServer looks for a server this driver previously provisioned for
+	// machineName, by the same discovery labels CreateServer stamped on
+	// it, scoped to storePath so two machines sharing a name across
+	// different local stores/clusters can't be confused for each other.
+	// Used to make Create() idempotent after an interrupted run (adopt
+	// instead of duplicate) and to let Remove() recover a server whose ID
+	// the driver's own persisted state has lost.
+	FindServer(machineName, storePath string) (serverID int64, ipAddress string, found bool, err error)
+
+	// FindKey looks for an SSH key this driver previously uploaded for
+	// machineName, for the same reason and with the same storePath scoping
+	// as FindServer.
+	FindKey(machineName, storePath string) (keyID int64, found bool, err error)
+
+	// FindVolumes looks for volumes CreateServer created for machineName,
+	// for the same reason and with the same storePath scoping as
+	// FindServer. Used to let Remove() recover volume IDs the driver's own
+	// persisted state has lost, the same way it recovers ServerID/KeyID.
+	FindVolumes(machineName, storePath string) (volumeIDs []int64, err error)
+
+	PowerOn(serverID int64) error
+	PowerOff(serverID int64) error
+	Reboot(serverID int64) error
+	GetStatus(serverID int64) (state.State, error)
+}